@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	checkpointPath   = flag.String("checkpoint", "", "")
+	verifyMode       = flag.Bool("verify", false, "")
+	resumeOnlyFailed = flag.Bool("resume-only-failed", false, "")
+)
+
+// TileStatus is the state of one tile's POST as recorded in the
+// checkpoint manifest.
+type TileStatus string
+
+const (
+	StatusPending TileStatus = "pending"
+	StatusDone    TileStatus = "done"
+	StatusFailed  TileStatus = "failed"
+)
+
+// TileRecord is the checkpoint manifest entry for one (slabBegZ, ox, oy)
+// tile.
+type TileRecord struct {
+	SlabBegZ  int
+	OX        int
+	OY        int
+	Status    TileStatus
+	SHA256    string
+	URL       string
+	Timestamp time.Time
+}
+
+// Checkpoint is a manifest of tile POST outcomes, persisted to a JSON file
+// so that a failed multi-hour import can resume without re-uploading
+// tiles already known good.
+type Checkpoint struct {
+	path  string
+	mu    sync.Mutex
+	Tiles map[string]*TileRecord
+}
+
+func tileKey(slabBegZ, ox, oy int) string {
+	return fmt.Sprintf("%d_%d_%d", slabBegZ, ox, oy)
+}
+
+// loadCheckpoint reads the manifest at path, or returns an empty one if
+// the file doesn't exist yet.
+func loadCheckpoint(path string) (*Checkpoint, error) {
+	cp := &Checkpoint{path: path, Tiles: make(map[string]*TileRecord)}
+
+	jsonBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cp, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(jsonBytes, &cp.Tiles); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// get looks up the record for a tile, if any.  A nil Checkpoint (no
+// -checkpoint in effect) always reports no record.
+func (cp *Checkpoint) get(slabBegZ, ox, oy int) (TileRecord, bool) {
+	if cp == nil {
+		return TileRecord{}, false
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	rec, ok := cp.Tiles[tileKey(slabBegZ, ox, oy)]
+	if !ok {
+		return TileRecord{}, false
+	}
+	return *rec, true
+}
+
+// record stores rec in the manifest and atomically rewrites the
+// checkpoint file (write to ".tmp", fsync, rename).
+func (cp *Checkpoint) record(rec TileRecord) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.Tiles[tileKey(rec.SlabBegZ, rec.OX, rec.OY)] = &rec
+	return cp.saveLocked()
+}
+
+func (cp *Checkpoint) saveLocked() error {
+	jsonBytes, err := json.MarshalIndent(cp.Tiles, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := cp.path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(jsonBytes); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, cp.path)
+}
+
+// verifySlab checks, for every tile of slabBegZ with a "done" checkpoint
+// record, that a GET against DVID's raw endpoint returns data matching
+// the recorded digest -- instead of POSTing, as -verify does no writes.
+func verifySlab(ctx context.Context, config Config, slabBegZ int, checkpoint *Checkpoint) error {
+	slabEndZ := slabBegZ + *blocksize - 1
+	sendSize := 1024
+	mismatches := 0
+
+	for _, dir := range config.Directories {
+		if slabBegZ > dir.EndZ || slabEndZ < dir.BegZ {
+			continue
+		}
+		for oy := 0; oy < config.SizeY; oy += sendSize {
+			for ox := 0; ox < config.SizeX; ox += sendSize {
+				url := fmt.Sprintf("%s/%d_%d_%d", config.URI, ox, oy, slabBegZ)
+
+				rec, ok := checkpoint.get(slabBegZ, ox, oy)
+				if !ok || rec.Status != StatusDone {
+					fmt.Printf("Skipping verify of %s: no completed checkpoint record\n", url)
+					continue
+				}
+				if err := verifyTile(ctx, url, rec.SHA256); err != nil {
+					fmt.Printf("VERIFY FAILED %s: %s\n", url, err.Error())
+					mismatches++
+				}
+			}
+		}
+	}
+
+	if mismatches > 0 {
+		return fmt.Errorf("%d tile(s) failed verification for slab @ %d\n", mismatches, slabBegZ)
+	}
+	return nil
+}
+
+func verifyTile(ctx context.Context, url, wantSHA256 string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	r, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET returned status %d\n", r.StatusCode)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r.Body); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != wantSHA256 {
+		return fmt.Errorf("digest mismatch: got %s, want %s\n", got, wantSHA256)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Slab formats.  "gzip" is the original monolithic layout; the "chunked-*"
+// formats store each POST-sized tile as an independently compressed chunk
+// so processSlab can seek directly to the tile it needs instead of
+// decompressing the whole slab into memory.
+const (
+	formatGzip        = "gzip"
+	formatChunkedGzip = "chunked-gzip"
+	formatChunkedZstd = "chunked-zstd"
+)
+
+// ChunkEntry describes one compressed tile within a chunked slab file.
+type ChunkEntry struct {
+	TileX           int
+	TileY           int
+	Offset          int64
+	CompressedLen   int64
+	UncompressedLen int64
+	Digest          string // hex-encoded SHA-256 of the uncompressed tile
+}
+
+// TOC is the table of contents for a chunked slab file.  It is stored as a
+// "<slab file>.toc.json" sidecar and lists its chunks sorted in
+// (TileY, TileX) order so a tile can be located with a binary search.
+type TOC struct {
+	Format    string
+	TileSize  int
+	BlockSize int
+	Chunks    []ChunkEntry
+}
+
+func tocPath(slabFilename string) string {
+	return slabFilename + ".toc.json"
+}
+
+func readTOC(slabFilename string) (*TOC, error) {
+	jsonBytes, err := ioutil.ReadFile(tocPath(slabFilename))
+	if err != nil {
+		return nil, err
+	}
+	var toc TOC
+	if err := json.Unmarshal(jsonBytes, &toc); err != nil {
+		return nil, err
+	}
+	return &toc, nil
+}
+
+func writeTOC(slabFilename string, toc *TOC) error {
+	sort.Slice(toc.Chunks, func(i, j int) bool {
+		if toc.Chunks[i].TileY != toc.Chunks[j].TileY {
+			return toc.Chunks[i].TileY < toc.Chunks[j].TileY
+		}
+		return toc.Chunks[i].TileX < toc.Chunks[j].TileX
+	})
+	jsonBytes, err := json.MarshalIndent(toc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(tocPath(slabFilename), jsonBytes, 0644)
+}
+
+// findChunk binary searches the sorted TOC for the (tileX, tileY) entry.
+func (toc *TOC) findChunk(tileX, tileY int) (*ChunkEntry, bool) {
+	i := sort.Search(len(toc.Chunks), func(i int) bool {
+		c := toc.Chunks[i]
+		if c.TileY != tileY {
+			return c.TileY >= tileY
+		}
+		return c.TileX >= tileX
+	})
+	if i < len(toc.Chunks) && toc.Chunks[i].TileX == tileX && toc.Chunks[i].TileY == tileY {
+		return &toc.Chunks[i], true
+	}
+	return nil, false
+}
+
+func newChunkWriter(format string, w io.Writer) (io.WriteCloser, error) {
+	switch format {
+	case formatChunkedGzip:
+		return gzip.NewWriter(w), nil
+	case formatChunkedZstd:
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("Unknown chunked slab format %q\n", format)
+	}
+}
+
+func newChunkReader(format string, r io.Reader) (io.ReadCloser, error) {
+	switch format {
+	case formatChunkedGzip:
+		return gzip.NewReader(r)
+	case formatChunkedZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("Unknown chunked slab format %q\n", format)
+	}
+}
+
+// readTile decompresses the (tileX, tileY) tile of a chunked slab file
+// into dst, verifying it against the TOC digest.  f must implement
+// io.ReaderAt semantics for concurrent use (as *os.File does), since
+// readTile seeks independently via io.NewSectionReader rather than
+// touching f's shared offset.
+func readTile(f *os.File, toc *TOC, tileX, tileY int, dst []byte) error {
+	entry, found := toc.findChunk(tileX, tileY)
+	if !found {
+		return fmt.Errorf("No chunk found in TOC for tile (%d, %d)\n", tileX, tileY)
+	}
+	if entry.UncompressedLen != int64(len(dst)) {
+		return fmt.Errorf("Tile (%d, %d) TOC size %d does not match expected %d\n", tileX, tileY, entry.UncompressedLen, len(dst))
+	}
+
+	sr := io.NewSectionReader(f, entry.Offset, entry.CompressedLen)
+	cr, err := newChunkReader(toc.Format, sr)
+	if err != nil {
+		return err
+	}
+	defer cr.Close()
+
+	if _, err := io.ReadFull(cr, dst); err != nil {
+		return fmt.Errorf("Error reading tile (%d, %d): %s\n", tileX, tileY, err.Error())
+	}
+
+	digest := sha256.Sum256(dst)
+	if hex.EncodeToString(digest[:]) != entry.Digest {
+		return fmt.Errorf("Tile (%d, %d) failed digest check against TOC\n", tileX, tileY)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTOCFindChunk(t *testing.T) {
+	// writeTOC always leaves Chunks sorted in (TileY, TileX) order;
+	// findChunk's binary search depends on that invariant.
+	toc := &TOC{Chunks: []ChunkEntry{
+		{TileX: 0, TileY: 0},
+		{TileX: 1024, TileY: 0},
+		{TileX: 0, TileY: 1024},
+		{TileX: 1024, TileY: 1024},
+	}}
+
+	for _, tt := range []struct {
+		x, y int
+		want bool
+	}{
+		{0, 0, true},
+		{1024, 0, true},
+		{0, 1024, true},
+		{1024, 1024, true},
+		{2048, 0, false},
+		{0, 2048, false},
+		{1024, 2048, false},
+	} {
+		if _, found := toc.findChunk(tt.x, tt.y); found != tt.want {
+			t.Errorf("findChunk(%d, %d) found = %v, want %v", tt.x, tt.y, found, tt.want)
+		}
+	}
+}
+
+func TestPackReadTileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+
+	const (
+		sizeX    = 1536 // two tiles wide, second one partial
+		sizeY    = 1536 // two tiles tall, second one partial
+		blksize  = 2
+		sendSize = 1024
+	)
+	xyzBytes := sizeX * sizeY * blksize * 8
+
+	raw := make([]byte, xyzBytes)
+	for i := range raw {
+		raw[i] = byte(i % 251)
+	}
+
+	inputPath := filepath.Join(dir, "slab.gz")
+	writeGzipFile(t, inputPath, raw)
+
+	outputPath := filepath.Join(dir, "slab.chunked")
+	if err := packSlab(inputPath, outputPath, sizeX, sizeY, blksize, formatChunkedGzip); err != nil {
+		t.Fatalf("packSlab failed: %s", err)
+	}
+
+	toc, err := readTOC(outputPath)
+	if err != nil {
+		t.Fatalf("readTOC failed: %s", err)
+	}
+	if toc.BlockSize != blksize {
+		t.Errorf("toc.BlockSize = %d, want %d", toc.BlockSize, blksize)
+	}
+	if toc.TileSize != sendSize {
+		t.Errorf("toc.TileSize = %d, want %d", toc.TileSize, sendSize)
+	}
+
+	f, err := os.Open(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	sxyzBytes := sendSize * sendSize * blksize * 8
+	for oy := 0; oy < sizeY; oy += sendSize {
+		for ox := 0; ox < sizeX; ox += sendSize {
+			dst := make([]byte, sxyzBytes)
+			if err := readTile(f, toc, ox, oy, dst); err != nil {
+				t.Fatalf("readTile(%d, %d) failed: %s", ox, oy, err)
+			}
+			want := tileBytes(raw, sizeX, sizeY, blksize, ox, oy, sendSize)
+			if !bytes.Equal(dst, want) {
+				t.Errorf("tile (%d, %d) did not round-trip through pack/readTile", ox, oy)
+			}
+		}
+	}
+}
+
+func writeGzipFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	if _, err := gw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tileBytes replicates the tile-extraction formula used by both pack.go
+// and processSlab to compute the expected bytes for the given tile,
+// zero-padded to a full tile at the right/bottom edges.
+func tileBytes(raw []byte, sizeX, sizeY, blksize, ox, oy, sendSize int) []byte {
+	xBytes := sizeX * 8
+	xyBytes := xBytes * sizeY
+	sxBytes := sendSize * 8
+	sxyBytes := sxBytes * sendSize
+	sxyzBytes := sxyBytes * blksize
+
+	span := sendSize
+	endX := ox + sendSize
+	if endX > sizeX {
+		endX = sizeX
+		span -= ox + sendSize - sizeX
+	}
+	span *= 8
+
+	endY := oy + sendSize
+	if endY > sizeY {
+		endY = sizeY
+	}
+
+	buf := make([]byte, sxyzBytes)
+	for sz := 0; sz < blksize; sz++ {
+		for sy := oy; sy < endY; sy++ {
+			si := sz*xyBytes + sy*xBytes + ox*8
+			bi := sz*sxyBytes + (sy-oy)*sxBytes
+			copy(buf[bi:bi+span], raw[si:si+span])
+		}
+	}
+	return buf
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// readChunkSize bounds how much of the gzip stream we decompress per
+// io.ReadFull call, so a malformed or oversized slab file can't make us
+// balloon past the preallocated, exactly-sized destination buffer.
+const readChunkSize = 4 << 20 // 4 MiB
+
+// readBoundedGzip decompresses exactly `want` bytes from gr into a
+// preallocated buffer, reading in readChunkSize pieces so memory use
+// never exceeds the expected uncompressed size.  Any short read is
+// reported with the exact offset where the stream ran out, and any byte
+// remaining in gr past `want` is treated as an error rather than being
+// silently ignored.
+func readBoundedGzip(gr *gzip.Reader, want int) ([]byte, error) {
+	data := make([]byte, want)
+	lr := &io.LimitedReader{R: gr, N: int64(want)}
+
+	var total int
+	for total < want {
+		end := total + readChunkSize
+		if end > want {
+			end = want
+		}
+		n, err := io.ReadFull(lr, data[total:end])
+		total += n
+		if err != nil {
+			return nil, fmt.Errorf("Expected %d bytes from uncompressed gzip file, got %d instead (truncated at offset %d): %s\n", want, total, total, err.Error())
+		}
+	}
+
+	var extra [1]byte
+	if n, err := gr.Read(extra[:]); n > 0 || (err != nil && err != io.EOF) {
+		return nil, fmt.Errorf("Uncompressed gzip file has more than the expected %d bytes\n", want)
+	}
+	return data, nil
+}
+
+// verifySlabDigest checks data against a configured SHA256, falling back
+// to a "<filename>.sha256" sidecar if none was configured.  If neither is
+// present, verification is skipped.
+func verifySlabDigest(filename string, data []byte, configuredSHA256 string) error {
+	want := configuredSHA256
+	if want == "" {
+		sidecar, err := ioutil.ReadFile(filename + ".sha256")
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		fields := strings.Fields(string(sidecar))
+		if len(fields) == 0 {
+			return fmt.Errorf("Sidecar %q is empty\n", filename+".sha256")
+		}
+		want = fields[0]
+	}
+
+	digest := sha256.Sum256(data)
+	if got := hex.EncodeToString(digest[:]); got != want {
+		return fmt.Errorf("Slab file %q failed SHA256 check: got %s, want %s\n", filename, got, want)
+	}
+	return nil
+}
@@ -1,17 +1,20 @@
 package main
 
 import (
-	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
-	"path/filepath"
 	"runtime"
+	"time"
+
+	"golang.org/x/sync/errgroup"
 )
 
 var (
@@ -24,16 +27,32 @@ var (
 )
 
 const helpMessage = `
-dvid-import sends a series of label slabs to a DVID server. 
+dvid-import sends a series of label slabs to a DVID server.
 
 Usage: dvid-import [options] <config file>
+       dvid-import pack [options] <input .gz slab> <output slab>
+
+The "pack" subcommand converts a monolithic gzip slab into the chunked,
+seekable slab format; run "dvid-import pack -help" for its options.
 
 	    -blocksize      =number   Number of Z slices should be combined to form each label slab (default 32)
+	    -parallel       =number  Number of concurrent POST workers (default number of CPUs)
+	    -max-retries    =number  Number of times to retry a failed POST (default 5)
+	    -retry-base     =duration Base delay for POST retry backoff, e.g. "250ms" (default 250ms)
+	    -concurrent-fetches =number  Number of upcoming slabs to prefetch in the background for
+	                              non-local Directories (default 0, meaning fetch inline as needed)
+	    -checkpoint     =path    Checkpoint manifest file (default "<config file>.checkpoint.json")
+	    -resume-only-failed (flag) Only retry tiles the checkpoint marks "failed"
+	    -verify         (flag)    Don't POST; GET each "done" tile from DVID and check its digest
 
 	    -dryrun         (flag)    Don't actually POST data
 	-h, -help           (flag)    Show help message
 
-The configuration file should be JSON that gives the slabs to be imported and their Z range.  Example:
+The configuration file should be JSON that gives the slabs to be imported and their Z range.
+A directory's "Path" may be a local path (or "file://" URL), or an "http://"/"https://" or
+"s3://" URL, in which case slab files are fetched into a local cache before processing.  An
+optional "SHA256" (or a "<slab file>.sha256" sidecar) is checked against the uncompressed
+"gzip" format slab before it is sent.  Example:
 
 {
 	"URI": "http://emdata2.int.janelia.org:7000/api/653/M10_LO/raw/0_1_2/18534_10786_32/",
@@ -82,10 +101,37 @@ type Config struct {
 }
 
 type SlabDir struct {
+	// Path is a URL identifying where the slab files live: a bare path or
+	// "file://" for local disk (the default), "http://"/"https://" for a
+	// file server, or "s3://bucket/prefix" for an S3-compatible store.
 	Path     string
 	BegZ     int
 	EndZ     int
 	Template string
+
+	// Format is the on-disk layout of the slab files: "gzip" for the
+	// original monolithic layout (the default if empty), or
+	// "chunked-gzip"/"chunked-zstd" for the seekable chunked layout
+	// produced by "dvid-import pack", which stores a "<file>.toc.json"
+	// sidecar alongside each slab file.
+	Format string
+
+	// Endpoint, Region, Bucket, Prefix, and Profile are only used for an
+	// "s3://" Path.  Bucket/Prefix default to the host/path parsed out of
+	// Path; Endpoint overrides the default AWS endpoint for S3-compatible
+	// stores like MinIO or Ceph; Profile selects a named credential
+	// profile instead of the default AWS credential chain.
+	Endpoint string
+	Region   string
+	Bucket   string
+	Prefix   string
+	Profile  string
+
+	// SHA256 is the expected hex-encoded digest of the uncompressed "gzip"
+	// format slab file; if empty, a "<slab file>.sha256" sidecar is used
+	// instead if present.  Not used for the chunked formats, which verify
+	// each tile's digest against the TOC.
+	SHA256 string
 }
 
 func readConfig(filename string) Config {
@@ -111,6 +157,11 @@ func readConfig(filename string) Config {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "pack" {
+		runPack(os.Args[2:])
+		return
+	}
+
 	flag.BoolVar(showHelp, "h", false, "Show help message")
 	flag.Usage = usage
 	flag.Parse()
@@ -150,16 +201,46 @@ func main() {
 		maxZ = dir.EndZ
 	}
 
+	cacheDir, err := ioutil.TempDir("", "dvid-import-cache-")
+	if err != nil {
+		log.Fatalln("Could not create slab cache directory:", err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	var prefetch *prefetcher
+	if *concurrentFetches > 0 {
+		prefetch = newPrefetcher(cacheDir, *concurrentFetches)
+	}
+
+	if *checkpointPath == "" {
+		*checkpointPath = args[0] + ".checkpoint.json"
+	}
+	checkpoint, err := loadCheckpoint(*checkpointPath)
+	if err != nil {
+		log.Fatalln("Could not load checkpoint manifest:", err)
+	}
+
 	// Process each directory, label slab by label slab.
 	for slabBegZ := config.BegZ; slabBegZ <= config.EndZ; slabBegZ += *blocksize {
-		if err := processSlab(config, slabBegZ); err != nil {
+		if *verifyMode {
+			if err := verifySlab(context.Background(), config, slabBegZ, checkpoint); err != nil {
+				fmt.Printf("Error verifying slab @ %d: %s\n", slabBegZ, err.Error())
+				os.Exit(1)
+			}
+			continue
+		}
+
+		for i := 1; i <= *concurrentFetches; i++ {
+			prefetch.warm(context.Background(), config, slabBegZ+i**blocksize)
+		}
+		if err := processSlab(config, slabBegZ, cacheDir, checkpoint); err != nil {
 			fmt.Printf("Error processing slab @ %d: %s\n", slabBegZ, err.Error())
 			os.Exit(1)
 		}
 	}
 }
 
-func processSlab(config Config, slabBegZ int) error {
+func processSlab(config Config, slabBegZ int, cacheDir string, checkpoint *Checkpoint) error {
 	fmt.Printf("Processing slab starting at %d ...\n", slabBegZ)
 
 	slabEndZ := slabBegZ + *blocksize - 1
@@ -172,37 +253,83 @@ func processSlab(config Config, slabBegZ int) error {
 	sxyBytes := sxBytes * sendSize
 	sxyzBytes := sxyBytes * *blocksize
 
+	g, ctx := errgroup.WithContext(context.Background())
+	jobCh := make(chan postJob, *parallel*2)
+	for i := 0; i < *parallel; i++ {
+		g.Go(func() error {
+			return postTiles(ctx, jobCh, checkpoint)
+		})
+	}
+
 	// Iterate through all directories and fill in byte buffer when intersecting.
+dirLoop:
 	for _, dir := range config.Directories {
 		if slabBegZ > dir.EndZ || slabEndZ < dir.BegZ {
 			continue
 		}
 
-		// Get the file
-		filename := filepath.Join(dir.Path, fmt.Sprintf(dir.Template, slabBegZ))
-		fmt.Printf("Getting data for Z %d -> %d from %s ...\n", slabBegZ, slabEndZ, filename)
+		// Resolve the directory's backend and fetch (or locate) the file.
+		key := fmt.Sprintf(dir.Template, slabBegZ)
+		fmt.Printf("Getting data for Z %d -> %d from %s%s ...\n", slabBegZ, slabEndZ, dir.Path, key)
 
-		var f *os.File
-		var err error
-		if f, err = os.Open(filename); err != nil {
-			return err
+		format := dir.Format
+		if format == "" {
+			format = formatGzip
 		}
-		defer f.Close()
 
-		// Read and uncompress the data.
-		gr, err := gzip.NewReader(f)
+		src, err := resolveSource(dir)
 		if err != nil {
 			return err
 		}
-		data, err := ioutil.ReadAll(gr)
+		chunked := format == formatChunkedGzip || format == formatChunkedZstd
+		filename, err := localSlabPath(ctx, src, key, cacheDir, chunked)
 		if err != nil {
 			return err
 		}
-		if err = gr.Close(); err != nil {
+
+		var f *os.File
+		if f, err = os.Open(filename); err != nil {
 			return err
 		}
-		if len(data) != xyzBytes {
-			return fmt.Errorf("Expected %d bytes from uncompressed gzip file, got %d instead.\n", xyzBytes, len(data))
+		defer f.Close()
+
+		// For the monolithic gzip format we decompress the whole slab up
+		// front; for the chunked formats we only load the TOC here and
+		// decompress each tile lazily below via readTile.
+		var data []byte
+		var toc *TOC
+		switch format {
+		case formatGzip:
+			gr, err := gzip.NewReader(f)
+			if err != nil {
+				return err
+			}
+			data, err = readBoundedGzip(gr, xyzBytes)
+			if err != nil {
+				return err
+			}
+			if err = gr.Close(); err != nil {
+				return err
+			}
+			if err := verifySlabDigest(filename, data, dir.SHA256); err != nil {
+				return err
+			}
+		case formatChunkedGzip, formatChunkedZstd:
+			toc, err = readTOC(filename)
+			if err != nil {
+				return err
+			}
+			if toc.Format != format {
+				return fmt.Errorf("Directory %q declares format %q but TOC says %q\n", dir.Path, format, toc.Format)
+			}
+			if toc.BlockSize != *blocksize {
+				return fmt.Errorf("Directory %q TOC blocksize %d does not match -blocksize %d\n", dir.Path, toc.BlockSize, *blocksize)
+			}
+			if toc.TileSize != sendSize {
+				return fmt.Errorf("Directory %q TOC tile size %d does not match expected %d\n", dir.Path, toc.TileSize, sendSize)
+			}
+		default:
+			return fmt.Errorf("Directory %q has unknown Format %q\n", dir.Path, format)
 		}
 
 		// Iterate through X and Y until we've sent smaller block-aligned POSTs for entire slab.
@@ -214,6 +341,11 @@ func processSlab(config Config, slabBegZ int) error {
 			for ox := 0; ox < config.SizeX; ox += sendSize {
 				url := fmt.Sprintf("%s/%d_%d_%d", config.URI, ox, oy, slabBegZ)
 
+				rec, hasRec := checkpoint.get(slabBegZ, ox, oy)
+				if *resumeOnlyFailed && (!hasRec || rec.Status != StatusFailed) {
+					continue
+				}
+
 				span := sendSize
 				endX := ox + sendSize
 				if endX > config.SizeX {
@@ -222,30 +354,70 @@ func processSlab(config Config, slabBegZ int) error {
 				}
 				span *= 8 // this is # of bytes per X we are xfering from slab
 
-				// Store data from slab into the POST buffer
-				bytebuf := make([]byte, sxyzBytes, sxyzBytes)
-				for sz := 0; sz < *blocksize; sz++ {
-					for sy := oy; sy < endY; sy++ {
-						si := sz*xyBytes + sy*xBytes
-						bi := sz*sxyBytes + sy*sxBytes
-						copy(bytebuf[bi:bi+span], data[si:si+span])
+				// Store data from slab into the POST buffer.  Buffers come
+				// from a pool and may carry a previous tile's bytes, so
+				// edge tiles (which don't fill the whole buffer) must be
+				// zeroed before copying in.
+				bytebuf := getBuf(sxyzBytes)
+				if format == formatGzip {
+					if span != sendSize*8 || endY-oy != sendSize {
+						for i := range bytebuf {
+							bytebuf[i] = 0
+						}
+					}
+					for sz := 0; sz < *blocksize; sz++ {
+						for sy := oy; sy < endY; sy++ {
+							si := sz*xyBytes + sy*xBytes + ox*8
+							bi := sz*sxyBytes + (sy-oy)*sxBytes
+							copy(bytebuf[bi:bi+span], data[si:si+span])
+						}
+					}
+				} else {
+					if err := readTile(f, toc, ox, oy, bytebuf); err != nil {
+						return err
+					}
+				}
+
+				// Skip tiles the checkpoint already has as done with a
+				// matching content hash.
+				if hasRec && rec.Status == StatusDone {
+					digest := sha256.Sum256(bytebuf)
+					if hex.EncodeToString(digest[:]) == rec.SHA256 {
+						fmt.Printf("Skipping %s: already done per checkpoint\n", url)
+						putBuf(sxyzBytes, bytebuf)
+						continue
 					}
 				}
 
 				// Send the data
 				fmt.Printf("POSTing: %s\n", url)
-				if !*dryrun {
-					r, err := http.Post(url, "application/octet-stream", bytes.NewBuffer(bytebuf))
-					if err != nil {
-						return err
+				if *dryrun {
+					putBuf(sxyzBytes, bytebuf)
+					continue
+				}
+				if checkpoint != nil {
+					digest := sha256.Sum256(bytebuf)
+					pending := TileRecord{
+						SlabBegZ:  slabBegZ,
+						OX:        ox,
+						OY:        oy,
+						Status:    StatusPending,
+						SHA256:    hex.EncodeToString(digest[:]),
+						URL:       url,
+						Timestamp: time.Now(),
 					}
-					if r.StatusCode != http.StatusOK {
-						return fmt.Errorf("Received bad status from POST on %q: %d\n", url, r.StatusCode)
+					if err := checkpoint.record(pending); err != nil {
+						fmt.Printf("Warning: could not write checkpoint for %s: %s\n", url, err.Error())
 					}
 				}
-				return nil
+				select {
+				case jobCh <- postJob{url: url, buf: bytebuf, slabBegZ: slabBegZ, ox: ox, oy: oy}:
+				case <-ctx.Done():
+					break dirLoop
+				}
 			}
 		}
 	}
-	return nil
+	close(jobCh)
+	return g.Wait()
 }
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+const packHelpMessage = `
+dvid-import pack converts a monolithic gzip label slab into the chunked,
+seekable slab format so that processSlab can stream individual tiles
+instead of decompressing the whole slab into memory.
+
+Usage: dvid-import pack [options] <input .gz slab> <output slab>
+
+	    -sizex      =number   Width in voxels of the slab (required)
+	    -sizey      =number   Height in voxels of the slab (required)
+	    -thickness  =number   Number of Z slices in the slab (default 32)
+	    -format     =string   Chunked format to write: chunked-gzip or chunked-zstd (default chunked-gzip)
+
+The output is the chunked slab file plus a "<output>.toc.json" sidecar
+table of contents.
+`
+
+func runPack(args []string) {
+	fs := flag.NewFlagSet("pack", flag.ExitOnError)
+	sizeX := fs.Int("sizex", 0, "")
+	sizeY := fs.Int("sizey", 0, "")
+	thickness := fs.Int("thickness", 32, "")
+	format := fs.String("format", formatChunkedGzip, "")
+	fs.Usage = func() { fmt.Print(packHelpMessage) }
+	fs.Parse(args)
+
+	rest := fs.Args()
+	if *sizeX <= 0 || *sizeY <= 0 || len(rest) != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *format != formatChunkedGzip && *format != formatChunkedZstd {
+		fmt.Printf("Unknown -format %q: must be %q or %q\n", *format, formatChunkedGzip, formatChunkedZstd)
+		os.Exit(1)
+	}
+
+	if err := packSlab(rest[0], rest[1], *sizeX, *sizeY, *thickness, *format); err != nil {
+		log.Fatalln("pack:", err)
+	}
+}
+
+// packSlab decompresses a whole monolithic gzip slab file into memory and
+// rewrites it tile-by-tile as a chunked slab, with each
+// 1024x1024xblocksize tile compressed independently and indexed in a TOC
+// sidecar.
+func packSlab(inputPath, outputPath string, sizeX, sizeY, blocksize int, format string) error {
+	xBytes := sizeX * 8
+	xyBytes := xBytes * sizeY
+	xyzBytes := xyBytes * blocksize
+
+	sendSize := 1024
+	sxBytes := sendSize * 8
+	sxyBytes := sxBytes * sendSize
+	sxyzBytes := sxyBytes * blocksize
+
+	in, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	gr, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(gr)
+	if err != nil {
+		return err
+	}
+	if err := gr.Close(); err != nil {
+		return err
+	}
+	if len(data) != xyzBytes {
+		return fmt.Errorf("Expected %d bytes from uncompressed gzip file, got %d instead.\n", xyzBytes, len(data))
+	}
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	toc := &TOC{Format: format, TileSize: sendSize, BlockSize: blocksize}
+	var offset int64
+
+	for oy := 0; oy < sizeY; oy += sendSize {
+		endY := oy + sendSize
+		if endY > sizeY {
+			endY = sizeY
+		}
+		for ox := 0; ox < sizeX; ox += sendSize {
+			span := sendSize
+			endX := ox + sendSize
+			if endX > sizeX {
+				endX = sizeX
+				span -= ox + sendSize - sizeX
+			}
+			span *= 8
+
+			// Tile boundaries must be block-aligned, so every tile buffer
+			// is the full 1024x1024xblocksize size, zero-padded at the
+			// right/bottom edges -- this matches what processSlab builds
+			// in memory for the POST.
+			bytebuf := make([]byte, sxyzBytes, sxyzBytes)
+			for sz := 0; sz < blocksize; sz++ {
+				for sy := oy; sy < endY; sy++ {
+					si := sz*xyBytes + sy*xBytes + ox*8
+					bi := sz*sxyBytes + (sy-oy)*sxBytes
+					copy(bytebuf[bi:bi+span], data[si:si+span])
+				}
+			}
+
+			var compressed bytes.Buffer
+			cw, err := newChunkWriter(format, &compressed)
+			if err != nil {
+				return err
+			}
+			if _, err := cw.Write(bytebuf); err != nil {
+				return err
+			}
+			if err := cw.Close(); err != nil {
+				return err
+			}
+
+			if _, err := out.Write(compressed.Bytes()); err != nil {
+				return err
+			}
+
+			digest := sha256.Sum256(bytebuf)
+			toc.Chunks = append(toc.Chunks, ChunkEntry{
+				TileX:           ox,
+				TileY:           oy,
+				Offset:          offset,
+				CompressedLen:   int64(compressed.Len()),
+				UncompressedLen: int64(sxyzBytes),
+				Digest:          hex.EncodeToString(digest[:]),
+			})
+			offset += int64(compressed.Len())
+		}
+	}
+
+	return writeTOC(outputPath, toc)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	parallel   = flag.Int("parallel", runtime.NumCPU(), "")
+	maxRetries = flag.Int("max-retries", 5, "")
+	retryBase  = flag.Duration("retry-base", 250*time.Millisecond, "")
+)
+
+// httpClient is shared across all tile POSTs (and -verify GETs) so that
+// connections can be kept alive and reused.  Its timeout is tuned for a
+// single tile request (8 MiB per Z slice, 256 MiB at the default
+// -blocksize of 32), not for downloading whole slab files.
+var httpClient = &http.Client{
+	Timeout: 2 * time.Minute,
+	Transport: &http.Transport{
+		MaxIdleConnsPerHost: 64,
+		IdleConnTimeout:     90 * time.Second,
+		DisableCompression:  true,
+	},
+}
+
+// fetchClient is shared across whole-file slab fetches (httpSource GETs,
+// prefetching) instead of httpClient, since a monolithic slab file can be
+// multi-GiB and take far longer than a single tile POST.
+var fetchClient = &http.Client{
+	Timeout: 30 * time.Minute,
+	Transport: &http.Transport{
+		MaxIdleConnsPerHost: 16,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
+
+// postJob is one block-aligned tile waiting to be POSTed.
+type postJob struct {
+	url      string
+	buf      []byte
+	slabBegZ int
+	ox, oy   int
+}
+
+// bufPools hands out []byte slices sized for POST buffers from a
+// sync.Pool keyed on size, so we don't allocate a new tile-sized buffer
+// (8 MiB per Z slice of the tile) for every tile.
+var bufPools sync.Map // map[int]*sync.Pool
+
+func getBuf(size int) []byte {
+	v, _ := bufPools.LoadOrStore(size, &sync.Pool{
+		New: func() interface{} { return make([]byte, size) },
+	})
+	return v.(*sync.Pool).Get().([]byte)
+}
+
+func putBuf(size int, buf []byte) {
+	v, ok := bufPools.Load(size)
+	if !ok {
+		return
+	}
+	v.(*sync.Pool).Put(buf[:size])
+}
+
+// postTiles drains jobs off jobCh and POSTs each one, retrying
+// transient failures, until jobCh is closed or ctx is cancelled by a
+// sibling worker's error.  If checkpoint is non-nil, every outcome
+// (success or failure) is recorded to it, with the digest taken before
+// the buffer is released.
+func postTiles(ctx context.Context, jobCh <-chan postJob, checkpoint *Checkpoint) error {
+	for {
+		select {
+		case job, ok := <-jobCh:
+			if !ok {
+				return nil
+			}
+			digest := sha256.Sum256(job.buf)
+			err := postWithRetry(ctx, job.url, job.buf)
+			putBuf(len(job.buf), job.buf)
+
+			if checkpoint != nil {
+				status := StatusDone
+				if err != nil {
+					status = StatusFailed
+				}
+				rec := TileRecord{
+					SlabBegZ:  job.slabBegZ,
+					OX:        job.ox,
+					OY:        job.oy,
+					Status:    status,
+					SHA256:    hex.EncodeToString(digest[:]),
+					URL:       job.url,
+					Timestamp: time.Now(),
+				}
+				if recErr := checkpoint.record(rec); recErr != nil {
+					fmt.Printf("Warning: could not write checkpoint for %s: %s\n", job.url, recErr.Error())
+				}
+			}
+
+			if err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// statusError is returned when a POST completes but with a non-200
+// status; 5xx is treated as retryable, everything else is not.
+type statusError struct {
+	url  string
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("Received bad status from POST on %q: %d\n", e.url, e.code)
+}
+
+func postWithRetry(ctx context.Context, url string, buf []byte) error {
+	var err error
+	for attempt := 0; attempt <= *maxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(retryBackoff(attempt)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			fmt.Printf("Retrying POST %s (attempt %d/%d) after: %s\n", url, attempt, *maxRetries, err.Error())
+		}
+
+		err = doPost(ctx, url, buf)
+		if err == nil {
+			return nil
+		}
+		if !isRetryable(err) {
+			return err
+		}
+	}
+	return fmt.Errorf("POST %s failed after %d retries: %s\n", url, *maxRetries, err.Error())
+}
+
+func doPost(ctx context.Context, url string, buf []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	r, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, r.Body)
+		r.Body.Close()
+	}()
+
+	if r.StatusCode != http.StatusOK {
+		return &statusError{url: url, code: r.StatusCode}
+	}
+	return nil
+}
+
+// isRetryable reports whether err is worth retrying: a 5xx response, a
+// connection reset, or a network timeout.
+func isRetryable(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code >= 500
+	}
+	if errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout()
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+// retryBackoff returns an exponential backoff with full jitter for the
+// given (1-indexed) retry attempt.
+func retryBackoff(attempt int) time.Duration {
+	max := *retryBase * time.Duration(uint64(1)<<uint(attempt-1))
+	return time.Duration(rand.Int63n(int64(max) + 1))
+}
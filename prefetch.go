@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var concurrentFetches = flag.Int("concurrent-fetches", 0, "")
+
+// localSlabPath returns a path to a local file holding the bytes of key as
+// read from src, fetching it into cacheDir first if src isn't already a
+// local filesystem (a no-op for fileSource, which is already local). When
+// chunked is true, the "<key>.toc.json" sidecar readTOC needs is fetched
+// (or located) alongside the slab data file itself.
+func localSlabPath(ctx context.Context, src SlabSource, key, cacheDir string, chunked bool) (string, error) {
+	if fs, ok := src.(*fileSource); ok {
+		return filepath.Join(fs.root, key), nil
+	}
+
+	cachePath := filepath.Join(cacheDir, sanitizeCacheKey(key))
+	if fi, err := os.Stat(cachePath); err == nil {
+		if size, statErr := src.Stat(ctx, key); statErr == nil && size == fi.Size() {
+			if !chunked {
+				return cachePath, nil
+			}
+			if _, err := os.Stat(cachePath + ".toc.json"); err == nil {
+				return cachePath, nil
+			}
+		}
+	}
+	if err := fetchToCache(ctx, src, key, cachePath); err != nil {
+		return "", err
+	}
+	if chunked {
+		if err := fetchToCache(ctx, src, key+".toc.json", cachePath+".toc.json"); err != nil {
+			return "", err
+		}
+	}
+	return cachePath, nil
+}
+
+func fetchToCache(ctx context.Context, src SlabSource, key, cachePath string) error {
+	rc, _, err := src.Open(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		return err
+	}
+	tmpPath := cachePath + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, rc); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, cachePath)
+}
+
+func sanitizeCacheKey(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "/", "_"), string(os.PathSeparator), "_")
+}
+
+// prefetcher fetches upcoming slabs' files into cacheDir in the
+// background, bounded to n concurrent fetches, so that network latency is
+// hidden behind the POST work for the slab currently being processed.
+type prefetcher struct {
+	cacheDir string
+	sem      chan struct{}
+	started  sync.Map // "dir.Path|key" -> struct{}, to avoid duplicate fetches
+}
+
+func newPrefetcher(cacheDir string, n int) *prefetcher {
+	return &prefetcher{cacheDir: cacheDir, sem: make(chan struct{}, n)}
+}
+
+// warm kicks off background fetches for every directory in config that
+// intersects slabBegZ and isn't already local.
+func (p *prefetcher) warm(ctx context.Context, config Config, slabBegZ int) {
+	if p == nil {
+		return
+	}
+	slabEndZ := slabBegZ + *blocksize - 1
+	for _, dir := range config.Directories {
+		if slabBegZ > dir.EndZ || slabEndZ < dir.BegZ {
+			continue
+		}
+
+		src, err := resolveSource(dir)
+		if err != nil {
+			continue // processSlab will hit and report the same error synchronously
+		}
+		if _, ok := src.(*fileSource); ok {
+			continue // nothing to prefetch for local files
+		}
+
+		key := fmt.Sprintf(dir.Template, slabBegZ)
+		startedKey := dir.Path + "|" + key
+		if _, loaded := p.started.LoadOrStore(startedKey, struct{}{}); loaded {
+			continue
+		}
+
+		chunked := dir.Format == formatChunkedGzip || dir.Format == formatChunkedZstd
+		p.sem <- struct{}{}
+		go func(src SlabSource, key string, chunked bool) {
+			defer func() { <-p.sem }()
+			if _, err := localSlabPath(ctx, src, key, p.cacheDir, chunked); err != nil {
+				fmt.Printf("Prefetch of %q failed (will retry inline when needed): %s\n", key, err.Error())
+			}
+		}(src, key, chunked)
+	}
+}
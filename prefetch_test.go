@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestLocalSlabPathFetchesChunkedTOC verifies that localSlabPath pulls down
+// the "<key>.toc.json" sidecar alongside the slab data file itself when the
+// source is remote and the directory's format is chunked -- otherwise
+// readTOC has nothing to read once the data file lands in the cache.
+func TestLocalSlabPathFetchesChunkedTOC(t *testing.T) {
+	servedDir := t.TempDir()
+
+	const (
+		sizeX   = 1024
+		sizeY   = 1024
+		blksize = 2
+	)
+	raw := make([]byte, sizeX*sizeY*blksize*8)
+	for i := range raw {
+		raw[i] = byte(i % 251)
+	}
+	inputPath := filepath.Join(servedDir, "slab.gz")
+	writeGzipFile(t, inputPath, raw)
+
+	slabPath := filepath.Join(servedDir, "slab.chunked")
+	if err := packSlab(inputPath, slabPath, sizeX, sizeY, blksize, formatChunkedGzip); err != nil {
+		t.Fatalf("packSlab failed: %s", err)
+	}
+	os.Remove(inputPath)
+
+	ts := httptest.NewServer(http.FileServer(http.Dir(servedDir)))
+	defer ts.Close()
+
+	src := &httpSource{baseURL: ts.URL, client: ts.Client()}
+	cacheDir := t.TempDir()
+
+	filename, err := localSlabPath(context.Background(), src, "slab.chunked", cacheDir, true)
+	if err != nil {
+		t.Fatalf("localSlabPath failed: %s", err)
+	}
+
+	if _, err := os.Stat(tocPath(filename)); err != nil {
+		t.Fatalf("TOC sidecar was not fetched into the cache: %s", err)
+	}
+
+	toc, err := readTOC(filename)
+	if err != nil {
+		t.Fatalf("readTOC failed after fetching from a remote chunked source: %s", err)
+	}
+	if toc.BlockSize != blksize {
+		t.Errorf("toc.BlockSize = %d, want %d", toc.BlockSize, blksize)
+	}
+	if len(toc.Chunks) != 1 {
+		t.Errorf("len(toc.Chunks) = %d, want 1", len(toc.Chunks))
+	}
+}
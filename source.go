@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// SlabSource abstracts fetching a named slab file from wherever it lives:
+// local disk, an HTTP(S) server, or an S3-compatible object store.
+type SlabSource interface {
+	Open(ctx context.Context, key string) (io.ReadCloser, int64, error)
+	Stat(ctx context.Context, key string) (int64, error)
+}
+
+// resolveSource parses dir.Path as a URL and returns the SlabSource it
+// identifies.  A Path with no scheme (or "file://") is treated as a local
+// filesystem directory, so old-style configs keep working unchanged.
+func resolveSource(dir SlabDir) (SlabSource, error) {
+	u, err := url.Parse(dir.Path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse Path %q as a URL: %s\n", dir.Path, err.Error())
+	}
+
+	switch u.Scheme {
+	case "", "file":
+		root := dir.Path
+		if u.Scheme == "file" {
+			root = u.Path
+		}
+		return &fileSource{root: root}, nil
+
+	case "http", "https":
+		return &httpSource{baseURL: strings.TrimRight(dir.Path, "/"), client: fetchClient}, nil
+
+	case "s3":
+		bucket := dir.Bucket
+		if bucket == "" {
+			bucket = u.Host
+		}
+		prefix := dir.Prefix
+		if prefix == "" {
+			prefix = strings.TrimPrefix(u.Path, "/")
+		}
+		client, err := newS3Client(dir)
+		if err != nil {
+			return nil, err
+		}
+		return &s3Source{client: client, bucket: bucket, prefix: prefix}, nil
+
+	default:
+		return nil, fmt.Errorf("Unsupported Path scheme %q in %q\n", u.Scheme, dir.Path)
+	}
+}
+
+// fileSource reads slab files directly off the local filesystem.
+type fileSource struct {
+	root string
+}
+
+func (s *fileSource) Open(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	f, err := os.Open(filepath.Join(s.root, key))
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, fi.Size(), nil
+}
+
+func (s *fileSource) Stat(ctx context.Context, key string) (int64, error) {
+	fi, err := os.Stat(filepath.Join(s.root, key))
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// httpSource reads slab files from a plain HTTP(S) file server.
+type httpSource struct {
+	baseURL string
+	client  *http.Client
+}
+
+func (s *httpSource) Open(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL+"/"+key, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	r, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if r.StatusCode != http.StatusOK {
+		r.Body.Close()
+		return nil, 0, fmt.Errorf("GET %s returned status %d\n", req.URL, r.StatusCode)
+	}
+	return r.Body, r.ContentLength, nil
+}
+
+func (s *httpSource) Stat(ctx context.Context, key string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.baseURL+"/"+key, nil)
+	if err != nil {
+		return 0, err
+	}
+	r, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("HEAD %s returned status %d\n", req.URL, r.StatusCode)
+	}
+	return r.ContentLength, nil
+}
+
+// s3Source reads slab files from an S3-compatible object store, honoring
+// AWS_* env vars / shared config, with an optional endpoint override for
+// MinIO/Ceph and an optional named credential profile.
+type s3Source struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func (s *s3Source) objectKey(key string) string {
+	return strings.TrimLeft(s.prefix+"/"+key, "/")
+}
+
+func (s *s3Source) Open(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key)),
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	var size int64
+	if out.ContentLength != nil {
+		size = *out.ContentLength
+	}
+	return out.Body, size, nil
+}
+
+func (s *s3Source) Stat(ctx context.Context, key string) (int64, error) {
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key)),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if out.ContentLength == nil {
+		return 0, nil
+	}
+	return *out.ContentLength, nil
+}
+
+func newS3Client(dir SlabDir) (*s3.Client, error) {
+	ctx := context.Background()
+	var opts []func(*awsconfig.LoadOptions) error
+	if dir.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(dir.Region))
+	}
+	if dir.Profile != "" {
+		opts = append(opts, awsconfig.WithSharedConfigProfile(dir.Profile))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if dir.Endpoint != "" {
+			o.BaseEndpoint = aws.String(dir.Endpoint)
+			o.UsePathStyle = true
+		}
+	}), nil
+}